@@ -0,0 +1,330 @@
+// Command injectgen scans a package for functions annotated with a
+// "//inject:generate" comment and emits, for each one, a
+// func InvokeXxx(inj inject.Injector) ([]reflect.Value, error) wrapper that
+// resolves the function's parameters with typed inj.Get calls and then
+// calls it directly instead of going through reflect.Value.Call. The
+// generated file registers each wrapper in an init() via
+// inject.RegisterInvoker, so inject.Injector.Invoke picks it up
+// automatically and skips reflection on the call path.
+//
+// Methods are not scanned. Variadic functions, and any function whose name
+// was already seen, are skipped with a log message and get no wrapper.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// injectMarker标记一个函数需要生成对应的InvokeXxx包装函数
+const injectMarker = "inject:generate"
+
+// param 描述生成的包装函数里的一个参数或者返回值
+type param struct {
+	// Type 是该参数类型的Go源码表示，例如"*http.ResponseWriter"
+	Type string
+	// IsError 表示这个返回值就是内置的error接口
+	IsError bool
+}
+
+// genFunc 描述一个被标注了inject:generate的函数
+type genFunc struct {
+	Name    string
+	Params  []param
+	Results []param
+}
+
+// genFile 是渲染模板所需的全部数据
+type genFile struct {
+	Package    string
+	ModulePath string
+	Imports    []string
+	Funcs      []genFunc
+	NeedsFmt   bool
+}
+
+func main() {
+	output := flag.String("output", "injectgen_gen.go", "generated file name")
+	modulePath := flag.String("module", "github.com/tuhuayuan/inject", "import path of the inject package")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := run(dir, *output, *modulePath); err != nil {
+		log.Fatalf("injectgen: %v", err)
+	}
+}
+
+func run(dir, output, modulePath string) error {
+	fset := token.NewFileSet()
+	files, err := parseDir(fset, dir, output)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no Go files found in %s", dir)
+	}
+
+	gf := genFile{ModulePath: modulePath}
+	imports := map[string]string{} // package name used in source -> import path
+	seenFuncs := map[string]bool{}
+
+	for _, f := range files {
+		gf.Package = f.Name.Name
+		fileImports := importsOf(f)
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || !hasInjectMarker(fn.Doc) {
+				continue
+			}
+			if fn.Type.Params != nil {
+				if n := len(fn.Type.Params.List); n > 0 {
+					if fn.Type.Params.List[n-1].Type != nil {
+						if _, ok := fn.Type.Params.List[n-1].Type.(*ast.Ellipsis); ok {
+							log.Printf("injectgen: skipping variadic func %s", fn.Name.Name)
+							continue
+						}
+					}
+				}
+			}
+
+			if seenFuncs[fn.Name.Name] {
+				log.Printf("injectgen: skipping duplicate func name %s", fn.Name.Name)
+				continue
+			}
+
+			g := genFunc{Name: fn.Name.Name}
+			g.Params, err = expandFields(fset, fn.Type.Params, fileImports, imports)
+			if err != nil {
+				return fmt.Errorf("func %s: %w", fn.Name.Name, err)
+			}
+			g.Results, err = expandFields(fset, fn.Type.Results, fileImports, imports)
+			if err != nil {
+				return fmt.Errorf("func %s: %w", fn.Name.Name, err)
+			}
+
+			if len(g.Params) > 0 {
+				gf.NeedsFmt = true
+			}
+
+			seenFuncs[fn.Name.Name] = true
+			gf.Funcs = append(gf.Funcs, g)
+		}
+	}
+
+	if len(gf.Funcs) == 0 {
+		return fmt.Errorf("no function in %s is annotated with //%s", dir, injectMarker)
+	}
+
+	for _, path := range imports {
+		gf.Imports = append(gf.Imports, path)
+	}
+
+	src, err := render(gf)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, output), src, 0o644)
+}
+
+// parseDir解析目录下所有非测试、非指定输出文件的.go文件
+func parseDir(fset *token.FileSet, dir, output string) ([]*ast.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ast.File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if strings.HasSuffix(name, "_test.go") || name == output {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// hasInjectMarker判断函数的文档注释里是否有一行是"inject:generate"。注意不能
+// 用CommentGroup.Text()，它会把"word:word"这种形如编译指示的行整行去掉
+func hasInjectMarker(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		line := strings.TrimPrefix(c.Text, "//")
+		if strings.TrimSpace(line) == injectMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// importsOf返回文件里 包名(或者别名) -> 导入路径 的映射
+func importsOf(f *ast.File) map[string]string {
+	result := make(map[string]string)
+	for _, imp := range f.Imports {
+		path := strings.Trim(imp.Path.Value, `"`)
+		name := filepath.Base(path)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		result[name] = path
+	}
+	return result
+}
+
+// expandFields把一个*ast.FieldList(参数或者返回值列表)展开成每个位置一个param，
+// 同时把表达式里用到的包名记录到needed里，供最终生成import使用
+func expandFields(fset *token.FileSet, list *ast.FieldList, fileImports, needed map[string]string) ([]param, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	var params []param
+	for _, field := range list.List {
+		typeStr, err := exprString(fset, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		collectImports(field.Type, fileImports, needed)
+
+		isErr := isErrorType(field.Type)
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, param{Type: typeStr, IsError: isErr})
+		}
+	}
+	return params, nil
+}
+
+// isErrorType判断一个类型表达式是不是内置的error接口
+func isErrorType(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// exprString把一个类型表达式原样渲染成Go源码文本
+func exprString(fset *token.FileSet, expr ast.Expr) (string, error) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, expr); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// collectImports递归找出类型表达式里用到的所有包选择符(例如http.ResponseWriter
+// 里的http)，并把对应的导入路径记录到needed
+func collectImports(expr ast.Expr, fileImports, needed map[string]string) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if path, ok := fileImports[ident.Name]; ok {
+			needed[ident.Name] = path
+		}
+		return true
+	})
+}
+
+var tmpl = template.Must(template.New("injectgen").Parse(`// Code generated by injectgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .NeedsFmt}}
+	"fmt"
+{{- end}}
+	"reflect"
+
+	inject "{{.ModulePath}}"
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+
+{{range .Funcs}}
+// Invoke{{.Name}} resolves {{.Name}}'s parameters from inj and calls it
+// directly, without going through reflect.Value.Call.
+func Invoke{{.Name}}(inj inject.Injector) ([]reflect.Value, error) {
+{{- range $i, $p := .Params}}
+	v{{$i}} := inj.Get(reflect.TypeOf((*{{$p.Type}})(nil)).Elem())
+	if !v{{$i}}.IsValid() {
+		return nil, fmt.Errorf("inject: value not found for type {{$p.Type}}")
+	}
+	arg{{$i}} := v{{$i}}.Interface().({{$p.Type}})
+{{- end}}
+
+{{- if .Results}}
+	{{range $i, $r := .Results}}{{if $i}}, {{end}}res{{$i}}{{end}} := {{.Name}}({{range $i, $p := .Params}}{{if $i}}, {{end}}arg{{$i}}{{end}})
+{{- range $i, $r := .Results}}
+{{- if $r.IsError}}
+	res{{$i}}Val := reflect.New(reflect.TypeOf((*error)(nil)).Elem()).Elem()
+	if res{{$i}} != nil {
+		res{{$i}}Val.Set(reflect.ValueOf(res{{$i}}))
+	}
+{{- else}}
+	res{{$i}}Val := reflect.ValueOf(res{{$i}})
+{{- end}}
+{{- end}}
+	return []reflect.Value{
+{{- range $i, $r := .Results}}
+		res{{$i}}Val,
+{{- end}}
+	}, nil
+{{- else}}
+	{{.Name}}({{range $i, $p := .Params}}{{if $i}}, {{end}}arg{{$i}}{{end}})
+	return nil, nil
+{{- end}}
+}
+
+func init() {
+	inject.RegisterInvoker({{.Name}}, Invoke{{.Name}})
+}
+{{end}}
+`))
+
+func render(gf genFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, gf); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, buf.String())
+	}
+	return src, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixtureSrc = `package fixture
+
+import "fmt"
+
+type Greeter struct {
+	Name string
+}
+
+// Greet formats a greeting.
+//
+//inject:generate
+func Greet(g *Greeter, name string) string {
+	return fmt.Sprintf("%s, %s!", g.Name, name)
+}
+
+//inject:generate
+func Validate(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is required")
+	}
+	return nil
+}
+
+func notAnnotated(name string) string {
+	return name
+}
+`
+
+func TestRunGeneratesInvokeWrappers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fixture.go"), []byte(fixtureSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const output = "injectgen_gen.go"
+	if err := run(dir, output, "github.com/tuhuayuan/inject"); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := os.ReadFile(filepath.Join(dir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	if _, err := parser.ParseFile(fset, output, src, 0); err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, src)
+	}
+
+	for _, want := range []string{
+		"func InvokeGreet(inj inject.Injector)",
+		"func InvokeValidate(inj inject.Injector)",
+		"inject.RegisterInvoker(Greet, InvokeGreet)",
+		"inject.RegisterInvoker(Validate, InvokeValidate)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated file missing %q\n%s", want, src)
+		}
+	}
+
+	if strings.Contains(string(src), "notAnnotated") {
+		t.Errorf("generated file should not reference unannotated functions\n%s", src)
+	}
+}
+
+func TestRunNoAnnotatedFuncs(t *testing.T) {
+	dir := t.TempDir()
+	src := "package fixture\n\nfunc plain() {}\n"
+	if err := os.WriteFile(filepath.Join(dir, "fixture.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(dir, "injectgen_gen.go", "github.com/tuhuayuan/inject"); err == nil {
+		t.Fatal("expected error when no function is annotated")
+	}
+}
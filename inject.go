@@ -3,6 +3,9 @@ package inject
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // Injector 接口集合
@@ -12,6 +15,10 @@ type Injector interface {
 	TypeMapper
 	// 设置父级injector如果当前差找不到参数就往父级查找
 	SetParent(Injector)
+	// Provide 注册一个构造函数，ctor的参数会在首次需要其返回类型时从injector
+	// 递归解析，ctor可以返回(T, error)，非nil的error会从Invoke和Apply返回；
+	// Get没有error返回值，这种情况下只返回一个无效的reflect.Value
+	Provide(ctor interface{}, opts ...ProvideOption) error
 }
 
 // Applicator 接口
@@ -40,12 +47,82 @@ type TypeMapper interface {
 	Set(reflect.Type, reflect.Value) TypeMapper
 	// 返回指定类型映射的值，或者返回一个零值可以用v.isValid()检测
 	Get(reflect.Type) reflect.Value
+	// MapNamed 按照名称和reflect.TypeOf返回的类型映射，用于同一类型存在多个
+	// 实例的场景（例如两个*sql.DB），需要配合GetNamed或者
+	// `inject:"name=xxx"`标签一起使用才能取回
+	MapNamed(name string, val interface{}) TypeMapper
+	// GetNamed 按照名称和类型查找映射值，name为空等价于Get
+	GetNamed(name string, t reflect.Type) reflect.Value
 }
 
-// injector 内部结构体
+// nameKey 具名绑定的复合键，用来在同一个reflect.Type存在多个实例时区分它们
+type nameKey struct {
+	name string
+	typ  reflect.Type
+}
+
+// Scope 描述Provide注册的构造函数产出实例的生命周期
+type Scope int
+
+const (
+	// Singleton 整个injector生命周期内构造函数只会被调用一次，结果会被缓存，
+	// 这是Provide的默认scope
+	Singleton Scope = iota
+	// Transient 每次Get都会重新调用构造函数产生一个新实例
+	Transient
+)
+
+// ProvideOption 用于配置Provide注册的构造函数
+type ProvideOption func(*provider)
+
+// WithScope 设置构造函数产出实例的生命周期，不设置默认为Singleton
+func WithScope(scope Scope) ProvideOption {
+	return func(p *provider) {
+		p.scope = scope
+	}
+}
+
+// provider 保存一个构造函数及其产出类型的运行时状态，Singleton scope下
+// 用once保证并发场景下构造函数只被调用一次
+type provider struct {
+	ctor      reflect.Value
+	scope     Scope
+	outs      []reflect.Type
+	once      sync.Once
+	cached    []reflect.Value
+	cachedErr error
+}
+
+// errType error接口的reflect.Type，用来识别构造函数返回值里的错误
+var errType = reflect.TypeOf((*error)(nil)).Elem()
+
+// argTypesCache 缓存每个函数类型对应的参数类型列表，避免Invoke/Provide重复
+// 调用产生的构造函数在每次解析依赖时都要重新walk t.NumIn()
+var argTypesCache sync.Map // map[reflect.Type][]reflect.Type
+
+// argTypesOf 返回函数类型ft的参数类型列表，结果会被缓存
+func argTypesOf(ft reflect.Type) []reflect.Type {
+	if cached, ok := argTypesCache.Load(ft); ok {
+		return cached.([]reflect.Type)
+	}
+
+	types := make([]reflect.Type, ft.NumIn())
+	for i := range types {
+		types[i] = ft.In(i)
+	}
+	actual, _ := argTypesCache.LoadOrStore(ft, types)
+	return actual.([]reflect.Type)
+}
+
+// injector 内部结构体，mu保护values/named/providers/parent这些可变状态，
+// 使得同一个injector可以安全地在多个goroutine（例如每个请求一个的子injector）
+// 间并发使用
 type injector struct {
-	values map[reflect.Type]reflect.Value
-	parent Injector
+	mu        sync.RWMutex
+	values    map[reflect.Type]reflect.Value
+	named     map[nameKey]reflect.Value
+	providers map[reflect.Type]*provider
+	parent    Injector
 }
 
 // InterfaceOf 获取一个接口类型通过 (*http.ResponseWriter)(nil) 的方式
@@ -87,8 +164,24 @@ func IsFunction(f interface{}) bool {
 // New 创建一个Injector对象
 func New() Injector {
 	return &injector{
-		values: make(map[reflect.Type]reflect.Value),
+		values:    make(map[reflect.Type]reflect.Value),
+		named:     make(map[nameKey]reflect.Value),
+		providers: make(map[reflect.Type]*provider),
+	}
+}
+
+// invokerRegistry 保存injectgen生成的Invoke包装函数，以原始函数的
+// reflect.Value.Pointer()为键，使Invoke可以绕过reflect.Value.Call
+var invokerRegistry sync.Map // map[uintptr]func(Injector) ([]reflect.Value, error)
+
+// RegisterInvoker 注册一个由cmd/injectgen生成的、针对fn的零反射调用包装函数，
+// 通常在生成代码的init()里调用，无需手动使用。fn必须是具体的函数值而不是
+// 接口值，因为注册表以它的代码地址作为键
+func RegisterInvoker(fn interface{}, invoker func(Injector) ([]reflect.Value, error)) {
+	if !IsFunction(fn) {
+		panic("fn is not kind of reflect.Func")
 	}
+	invokerRegistry.Store(reflect.ValueOf(fn).Pointer(), invoker)
 }
 
 // Invoke 实现Invoker接口
@@ -96,12 +189,20 @@ func (inj *injector) Invoke(f interface{}) ([]reflect.Value, error) {
 	if !IsFunction(f) {
 		panic("f is not kine of reflect.Func")
 	}
-	t := reflect.TypeOf(f)
-	var in = make([]reflect.Value, t.NumIn())
 
-	for i := 0; i < t.NumIn(); i++ {
-		argType := t.In(i)
-		val := inj.Get(argType)
+	fv := reflect.ValueOf(f)
+	if invoker, ok := invokerRegistry.Load(fv.Pointer()); ok {
+		return invoker.(func(Injector) ([]reflect.Value, error))(inj)
+	}
+
+	argTypes := argTypesOf(fv.Type())
+	in := make([]reflect.Value, len(argTypes))
+
+	for i, argType := range argTypes {
+		val, err := inj.get(argType)
+		if err != nil {
+			return nil, err
+		}
 
 		if !val.IsValid() {
 			return nil, fmt.Errorf("Value not found for type %v", argType)
@@ -112,8 +213,131 @@ func (inj *injector) Invoke(f interface{}) ([]reflect.Value, error) {
 	return reflect.ValueOf(f).Call(in), nil
 }
 
+// Provide 实现Injector接口
+func (inj *injector) Provide(ctor interface{}, opts ...ProvideOption) error {
+	if !IsFunction(ctor) {
+		panic("ctor is not kind of reflect.Func")
+	}
+
+	ct := reflect.TypeOf(ctor)
+	outs := make([]reflect.Type, 0, ct.NumOut())
+	for i := 0; i < ct.NumOut(); i++ {
+		if ot := ct.Out(i); ot != errType {
+			outs = append(outs, ot)
+		}
+	}
+	if len(outs) == 0 {
+		return fmt.Errorf("inject: ctor %v must return at least one non-error value", ct)
+	}
+
+	p := &provider{
+		ctor: reflect.ValueOf(ctor),
+		outs: outs,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	inj.mu.Lock()
+	for _, ot := range outs {
+		inj.providers[ot] = p
+	}
+	inj.mu.Unlock()
+	return nil
+}
+
+// resolveProvider 调用构造函数产生类型t的实例。path记录了当前这次解析经过的
+// 类型，用来检测循环依赖；它是每次调用独立的局部切片而不是injector上的共享
+// 状态，这样并发的多次解析互不干扰。Singleton scope下用provider.once保证
+// 构造函数在所有goroutine中只被调用一次
+func (inj *injector) resolveProvider(t reflect.Type, p *provider, path []reflect.Type) (reflect.Value, error) {
+	// 不仅检查t本身，还检查这个构造函数的其它产出类型：构造函数依赖自己的
+	// 另一个返回类型同样是循环，否则会在p.once.Do里重入而死锁
+	for _, rt := range path {
+		for _, ot := range p.outs {
+			if rt == ot {
+				return reflect.Value{}, fmt.Errorf("inject: cycle detected resolving %s", formatCycle(path, t))
+			}
+		}
+	}
+
+	if p.scope != Singleton {
+		outs, err := inj.invokeProvider(p, path, t)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return outValueFor(p, outs, t)
+	}
+
+	p.once.Do(func() {
+		p.cached, p.cachedErr = inj.invokeProvider(p, path, t)
+	})
+	if p.cachedErr != nil {
+		return reflect.Value{}, p.cachedErr
+	}
+	return outValueFor(p, p.cached, t)
+}
+
+// invokeProvider 解析构造函数的参数并调用它，返回剔除了error之后的结果
+func (inj *injector) invokeProvider(p *provider, path []reflect.Type, t reflect.Type) ([]reflect.Value, error) {
+	nextPath := append(append([]reflect.Type{}, path...), t)
+
+	argTypes := argTypesOf(p.ctor.Type())
+	in := make([]reflect.Value, len(argTypes))
+	for i, argType := range argTypes {
+		val, err := inj.getWithPath(argType, nextPath)
+		if err != nil {
+			return nil, err
+		}
+		if !val.IsValid() {
+			return nil, fmt.Errorf("Value not found for type %v", argType)
+		}
+		in[i] = val
+	}
+
+	rvs := p.ctor.Call(in)
+	outs := make([]reflect.Value, 0, len(p.outs))
+	for _, rv := range rvs {
+		if rv.Type() == errType {
+			if !rv.IsNil() {
+				return nil, rv.Interface().(error)
+			}
+			continue
+		}
+		outs = append(outs, rv)
+	}
+	return outs, nil
+}
+
+// outValueFor 从构造函数的产出列表里取出类型t对应的那一个
+func outValueFor(p *provider, outs []reflect.Value, t reflect.Type) (reflect.Value, error) {
+	for i, ot := range p.outs {
+		if ot == t {
+			return outs[i], nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("inject: provider for %v produced no matching value", t)
+}
+
+// formatCycle 把正在解析的类型栈和重复出现的类型拼接成 "A -> B -> A" 形式
+func formatCycle(stack []reflect.Type, repeated reflect.Type) string {
+	var sb strings.Builder
+	for _, t := range stack {
+		sb.WriteString(t.String())
+		sb.WriteString(" -> ")
+	}
+	sb.WriteString(repeated.String())
+	return sb.String()
+}
+
 // Apply 实现Applicator接口.
 func (inj *injector) Apply(val interface{}) error {
+	return inj.applyWithPath(val, nil)
+}
+
+// applyWithPath 是Apply的内部实现，path记录了当前递归注入经过的struct类型，
+// 用来在`inject:"recursive"`字段之间检测循环依赖
+func (inj *injector) applyWithPath(val interface{}, path []reflect.Type) error {
 	v := reflect.ValueOf(val)
 
 	for v.Kind() == reflect.Ptr {
@@ -129,71 +353,239 @@ func (inj *injector) Apply(val interface{}) error {
 		f := v.Field(i)
 		structField := t.Field(i)
 		tv, found := structField.Tag.Lookup("inject")
-		if f.CanSet() {
-			if found {
-				ft := f.Type()
-				v := inj.Get(ft)
+		if !found || !f.CanSet() {
+			continue
+		}
+
+		optional, recursive, name := parseInjectTag(tv)
+		ft := f.Type()
+
+		var fv reflect.Value
+		var err error
+		if name != "" {
+			fv, err = inj.getNamed(name, ft)
+		} else {
+			fv, err = inj.get(ft)
+		}
+		if err != nil {
+			return err
+		}
+
+		if fv.IsValid() {
+			f.Set(fv)
+			continue
+		}
 
-				if !v.IsValid() && tv != "-" {
-					return fmt.Errorf("Value not found for type %v", ft)
+		// 字段类型没有在injector中注册，如果打了recursive标签而且字段是
+		// struct或者struct指针，就递归地对它调用Apply，而不是直接报错
+		if recursive {
+			st := ft
+			depth := 0
+			for st.Kind() == reflect.Ptr {
+				st = st.Elem()
+				depth++
+			}
+			ptr := depth == 1
+
+			if depth > 1 {
+				if optional {
+					continue
+				}
+				return fmt.Errorf("inject: recursive field %s.%s has type %v, only a struct or a single-level pointer to a struct is supported", t, structField.Name, ft)
+			}
+
+			if st.Kind() == reflect.Struct {
+				for _, pt := range path {
+					if pt == st {
+						return fmt.Errorf("inject: recursive apply cycle detected resolving %s", formatCycle(path, st))
+					}
+				}
+
+				target := f
+				if ptr && f.IsNil() {
+					target = reflect.New(st)
+				} else if !ptr {
+					target = f.Addr()
 				}
 
-				f.Set(v)
+				nextPath := append(append([]reflect.Type{}, path...), st)
+				if err := inj.applyWithPath(target.Interface(), nextPath); err != nil {
+					return err
+				}
+
+				if ptr {
+					f.Set(target)
+				}
+				continue
 			}
 		}
 
+		if optional {
+			continue
+		}
+		return fmt.Errorf("Value not found for type %v", ft)
 	}
 	return nil
 }
 
+// parseInjectTag 解析inject标签，按照`,`分割出各个选项，支持`-`(可选，取不到
+// 不报错)、`recursive`(字段未注册时递归地对struct/struct指针调用Apply，并为
+// nil指针分配新实例)和`name=xxx`(具名绑定)，未识别的选项(比如`required`)会
+// 被忽略，因为默认行为本来就是required
+func parseInjectTag(tv string) (optional bool, recursive bool, name string) {
+	for _, opt := range strings.Split(tv, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "-":
+			optional = true
+		case opt == "recursive":
+			recursive = true
+		case strings.HasPrefix(opt, "name="):
+			name = strings.TrimPrefix(opt, "name=")
+		}
+	}
+	return
+}
+
 // Maps 实现TypeMapper
 func (inj *injector) Map(val interface{}) TypeMapper {
+	inj.mu.Lock()
 	inj.values[reflect.TypeOf(val)] = reflect.ValueOf(val)
+	inj.mu.Unlock()
 	return inj
 }
 
 // MapTo 实现TypeMapper
 func (inj *injector) MapTo(val interface{}, ifacePtr interface{}) TypeMapper {
+	inj.mu.Lock()
 	inj.values[InterfaceOf(ifacePtr)] = reflect.ValueOf(val)
+	inj.mu.Unlock()
 	return inj
 }
 
 // Set 实现TypeMapper
 func (inj *injector) Set(typ reflect.Type, val reflect.Value) TypeMapper {
+	inj.mu.Lock()
 	inj.values[typ] = val
+	inj.mu.Unlock()
 	return inj
 }
 
 // Get 实现TypeMapper
 func (inj *injector) Get(t reflect.Type) reflect.Value {
+	val, _ := inj.get(t)
+	return val
+}
+
+// GetNamed 实现TypeMapper
+func (inj *injector) GetNamed(name string, t reflect.Type) reflect.Value {
+	val, _ := inj.getNamed(name, t)
+	return val
+}
+
+// MapNamed 实现TypeMapper
+func (inj *injector) MapNamed(name string, val interface{}) TypeMapper {
+	inj.mu.Lock()
+	inj.named[nameKey{name: name, typ: reflect.TypeOf(val)}] = reflect.ValueOf(val)
+	inj.mu.Unlock()
+	return inj
+}
+
+// get 查找指定类型映射的值，如果是接口类型而且有多个实现同时注册却没有用
+// name区分，返回一个"inject: ambiguous type"错误
+func (inj *injector) get(t reflect.Type) (reflect.Value, error) {
+	return inj.getWithPath(t, nil)
+}
+
+// getWithPath 是get的内部实现，path记录了当前解析链路经过的类型，Provide
+// 注册的构造函数用它来检测循环依赖，普通的Map/MapTo映射查找则忽略它
+func (inj *injector) getWithPath(t reflect.Type, path []reflect.Type) (reflect.Value, error) {
+	inj.mu.RLock()
 	val := inj.values[t]
+	inj.mu.RUnlock()
 
 	if val.IsValid() {
-		return val
+		return val, nil
 	}
 
-	// 没有直接类型匹配，查找接口实现匹配
+	// 没有直接类型匹配，查找接口实现匹配。候选类型按String()排序之后再遍历，
+	// 保证多次运行的结果是确定的，而不是依赖map的随机遍历顺序
 	if t.Kind() == reflect.Interface {
-		for k, v := range inj.values {
+		inj.mu.RLock()
+		var candidates []reflect.Type
+		for k := range inj.values {
 			if k.Implements(t) {
-				// 这里有一个随机的情况，如果映射的两个实际类型都实现同一个几口，
-				// 可能随机返回一个值
-				val = v
-				break
+				candidates = append(candidates, k)
 			}
 		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].String() < candidates[j].String()
+		})
+		if len(candidates) == 1 {
+			val = inj.values[candidates[0]]
+		}
+		inj.mu.RUnlock()
+
+		if len(candidates) > 1 {
+			return reflect.Value{}, fmt.Errorf("inject: ambiguous type %v, %d implementations registered (first %v), use MapNamed/GetNamed to disambiguate", t, len(candidates), candidates[0])
+		}
+	}
+
+	// 没有直接映射或接口实现，尝试通过Provide注册的构造函数生成
+	if !val.IsValid() {
+		inj.mu.RLock()
+		p, ok := inj.providers[t]
+		inj.mu.RUnlock()
+		if ok {
+			return inj.resolveProvider(t, p, path)
+		}
 	}
 
 	// 没有匹配到就直接向上查找
-	if !val.IsValid() && inj.parent != nil {
-		val = inj.parent.Get(t)
+	if !val.IsValid() {
+		inj.mu.RLock()
+		parent := inj.parent
+		inj.mu.RUnlock()
+
+		if parent != nil {
+			if p, ok := parent.(*injector); ok {
+				return p.getWithPath(t, path)
+			}
+			val = parent.Get(t)
+		}
 	}
 
-	return val
+	return val, nil
+}
+
+// getNamed 按照名称查找映射值，name为空等价于get
+func (inj *injector) getNamed(name string, t reflect.Type) (reflect.Value, error) {
+	if name == "" {
+		return inj.get(t)
+	}
+
+	inj.mu.RLock()
+	val := inj.named[nameKey{name: name, typ: t}]
+	parent := inj.parent
+	inj.mu.RUnlock()
+
+	if val.IsValid() {
+		return val, nil
+	}
+
+	if parent != nil {
+		if p, ok := parent.(*injector); ok {
+			return p.getNamed(name, t)
+		}
+		val = parent.GetNamed(name, t)
+	}
 
+	return val, nil
 }
 
 // SetParent 实现Injector接口
 func (inj *injector) SetParent(parent Injector) {
+	inj.mu.Lock()
 	inj.parent = parent
+	inj.mu.Unlock()
 }
@@ -3,6 +3,7 @@ package inject
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -133,3 +134,293 @@ func Test_InjectImplementors(t *testing.T) {
 
 	assert.Equal(t, inj.Get(InterfaceOf((*fmt.Stringer)(nil))).IsValid(), true)
 }
+
+func Test_InjectorMapNamedGetNamed(t *testing.T) {
+	inj := New()
+	assert.NotNil(t, inj)
+
+	primary := "primary-dsn"
+	replica := "replica-dsn"
+	inj.MapNamed("db.primary", primary)
+	inj.MapNamed("db.replica", replica)
+
+	assert.Equal(t, primary, inj.GetNamed("db.primary", reflect.TypeOf("")).String())
+	assert.Equal(t, replica, inj.GetNamed("db.replica", reflect.TypeOf("")).String())
+	assert.False(t, inj.GetNamed("db.missing", reflect.TypeOf("")).IsValid())
+}
+
+func Test_InjectorApplyNamed(t *testing.T) {
+	type NamedStruct struct {
+		Primary string `inject:"name=db.primary"`
+		Replica string `inject:"-,name=db.replica"`
+		Missing string `inject:"-,name=db.missing"`
+	}
+
+	inj := New()
+	inj.MapNamed("db.primary", "primary-dsn")
+	inj.MapNamed("db.replica", "replica-dsn")
+
+	s := NamedStruct{}
+	err := inj.Apply(&s)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary-dsn", s.Primary)
+	assert.Equal(t, "replica-dsn", s.Replica)
+	assert.Equal(t, "", s.Missing)
+}
+
+type OtherGreeter struct {
+	Name string
+}
+
+func (g *OtherGreeter) String() string {
+	return "Hi, " + g.Name
+}
+
+func Test_InjectorGetAmbiguous(t *testing.T) {
+	inj := New()
+	inj.Map(&Greeter{"Jeremy"})
+	inj.Map(&OtherGreeter{"Bob"})
+
+	val, err := inj.(*injector).get(InterfaceOf((*fmt.Stringer)(nil)))
+	assert.False(t, val.IsValid())
+	assert.Error(t, err)
+}
+
+type Counter struct {
+	n int
+}
+
+func Test_InjectorProvideSingleton(t *testing.T) {
+	inj := New()
+	calls := 0
+	err := inj.Provide(func() *Counter {
+		calls++
+		return &Counter{n: calls}
+	})
+	assert.NoError(t, err)
+
+	v1 := inj.Get(reflect.TypeOf(&Counter{}))
+	v2 := inj.Get(reflect.TypeOf(&Counter{}))
+	assert.Equal(t, 1, calls)
+	assert.Same(t, v1.Interface(), v2.Interface())
+}
+
+func Test_InjectorProvideTransient(t *testing.T) {
+	inj := New()
+	calls := 0
+	err := inj.Provide(func() *Counter {
+		calls++
+		return &Counter{n: calls}
+	}, WithScope(Transient))
+	assert.NoError(t, err)
+
+	v1 := inj.Get(reflect.TypeOf(&Counter{})).Interface().(*Counter)
+	v2 := inj.Get(reflect.TypeOf(&Counter{})).Interface().(*Counter)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, v1.n)
+	assert.Equal(t, 2, v2.n)
+}
+
+func Test_InjectorProvideWithError(t *testing.T) {
+	inj := New()
+	boom := fmt.Errorf("boom")
+	err := inj.Provide(func() (*Counter, error) {
+		return nil, boom
+	})
+	assert.NoError(t, err)
+
+	_, err = inj.Invoke(func(c *Counter) {})
+	assert.Equal(t, boom, err)
+}
+
+func Test_InjectorProvideDependencyChain(t *testing.T) {
+	inj := New()
+	assert.NoError(t, inj.Provide(func() string { return "jeremy" }))
+	assert.NoError(t, inj.Provide(func(name string) *Greeter { return &Greeter{name} }))
+
+	rvs, err := inj.Invoke(func(g *Greeter) string { return g.String() })
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, My name isjeremy", rvs[0].String())
+}
+
+func Test_InjectorProvideCycle(t *testing.T) {
+	type A struct{}
+	type B struct{}
+
+	inj := New()
+	assert.NoError(t, inj.Provide(func(*B) *A { return &A{} }))
+	assert.NoError(t, inj.Provide(func(*A) *B { return &B{} }))
+
+	_, err := inj.Invoke(func(*A) {})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle detected")
+}
+
+func Test_InjectorConcurrentMapGetInvoke(t *testing.T) {
+	inj := New()
+	inj.Map("dep1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			inj.Map(n)
+		}(i)
+		go func() {
+			defer wg.Done()
+			rvs, err := inj.Invoke(func(s string) string { return s })
+			assert.NoError(t, err)
+			assert.Equal(t, "dep1", rvs[0].String())
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_InjectorConcurrentProvideSingleton(t *testing.T) {
+	inj := New()
+	var calls int32
+	var mu sync.Mutex
+	assert.NoError(t, inj.Provide(func() *Counter {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return &Counter{n: 1}
+	}))
+
+	var wg sync.WaitGroup
+	results := make([]*Counter, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results[n] = inj.Get(reflect.TypeOf(&Counter{})).Interface().(*Counter)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+	for _, c := range results {
+		assert.Same(t, results[0], c)
+	}
+}
+
+type DBConfig struct {
+	DSN string `inject:"required,name=db.dsn"`
+}
+
+type ServiceConfig struct {
+	Name string    `inject:"required,name=svc.name"`
+	DB   *DBConfig `inject:"recursive"`
+}
+
+func Test_InjectorApplyRecursive(t *testing.T) {
+	// DB字段没有注册*DBConfig，但标了recursive，应该递归分配并填充
+	inj := New()
+	inj.MapNamed("svc.name", "svc")
+	inj.MapNamed("db.dsn", "db-dsn")
+
+	s := ServiceConfig{}
+	err := inj.Apply(&s)
+	assert.NoError(t, err)
+	assert.Equal(t, "svc", s.Name)
+	assert.NotNil(t, s.DB)
+	assert.Equal(t, "db-dsn", s.DB.DSN)
+}
+
+func Test_InjectorApplyRecursiveExistingPointer(t *testing.T) {
+	inj := New()
+	inj.MapNamed("svc.name", "svc")
+	inj.MapNamed("db.dsn", "db-dsn")
+
+	existing := &DBConfig{}
+	s := ServiceConfig{DB: existing}
+
+	err := inj.Apply(&s)
+	assert.NoError(t, err)
+	assert.Same(t, existing, s.DB)
+	assert.Equal(t, "db-dsn", s.DB.DSN)
+}
+
+type RecA struct {
+	B *RecB `inject:"recursive"`
+}
+
+type RecB struct {
+	A *RecA `inject:"recursive"`
+}
+
+func Test_InjectorApplyRecursiveCycle(t *testing.T) {
+	inj := New()
+
+	a := RecA{}
+	err := inj.Apply(&a)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle detected")
+}
+
+type DPInner struct {
+	V string `inject:"-"`
+}
+
+type DPOuter struct {
+	D **DPInner `inject:"recursive"`
+}
+
+func Test_InjectorApplyRecursiveRejectsDoublePointer(t *testing.T) {
+	inj := New()
+
+	o := DPOuter{}
+	assert.NotPanics(t, func() {
+		err := inj.Apply(&o)
+		assert.Error(t, err)
+	})
+}
+
+type DPOuterOptional struct {
+	D **DPInner `inject:"-,recursive"`
+}
+
+func Test_InjectorApplyRecursiveOptionalDoublePointerSkipped(t *testing.T) {
+	inj := New()
+
+	o := DPOuterOptional{}
+	err := inj.Apply(&o)
+	assert.NoError(t, err)
+	assert.Nil(t, o.D)
+}
+
+func greetWithName(name string) string {
+	return "Hello, " + name
+}
+
+func Test_InjectorInvokeUsesRegisteredInvoker(t *testing.T) {
+	used := false
+	RegisterInvoker(greetWithName, func(inj Injector) ([]reflect.Value, error) {
+		used = true
+		v := inj.Get(reflect.TypeOf(""))
+		return []reflect.Value{reflect.ValueOf(greetWithName(v.String()))}, nil
+	})
+
+	inj := New()
+	inj.Map("Jeremy")
+
+	rvs, err := inj.Invoke(greetWithName)
+	assert.NoError(t, err)
+	assert.True(t, used)
+	assert.Equal(t, "Hello, Jeremy", rvs[0].String())
+}
+
+func Benchmark_InvokeParallel(b *testing.B) {
+	inj := New()
+	inj.Map("dep1")
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := inj.Invoke(func(s string) string { return s })
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}